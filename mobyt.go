@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,13 +12,15 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
 )
 
 /*
@@ -39,19 +44,21 @@ import (
     ]
 }
 */
+type SMSHistoryEntry struct {
+	OrderID       string `json:"order_id"`
+	CreateTime    string `json:"create_time"`
+	ScheduleTime  string `json:"schedule_time"`
+	MessageType   string `json:"message_type"`
+	Sender        string `json:"sender"`
+	NumRecipients int    `json:"num_recipients"`
+}
+
 type SMSHistory struct {
-	Total      int    `json:total`
-	PageNumber int    `json:pageNumber`
-	Result     string `json:result`
-	PageSize   int    `json:pageSize`
-	SmsHistory []struct {
-		OrderID      string `json:order_id`
-		CreateTime   string `json:create_time`
-		ScheduleTime string `json:schedule_time`
-		MessageType  string `json:message_type`
-		Sender       string `json:sender`
-		NumRecipient string `json:num_recipient`
-	} `json:smshistory`
+	Total      int               `json:"total"`
+	PageNumber int               `json:"pageNumber"`
+	Result     string            `json:"result"`
+	PageSize   int               `json:"pageSize"`
+	SmsHistory []SMSHistoryEntry `json:"smshistory"`
 }
 
 /*
@@ -85,8 +92,8 @@ type SMSCredit struct {
 	Sms   []struct {
 		Type     string `json:"type"`
 		Quantity int    `json:"quantity"`
-	} `json:sms`
-	Email []struct {
+	} `json:"sms"`
+	Email struct {
 		BandWidth float64 `json:"bandwidth"`
 		Purchased string  `json:"purchased"`
 		Billing   string  `json:"billing"`
@@ -99,48 +106,266 @@ const login_uri = "/API/v1.0/REST/login"
 const status_uri = "/API/v1.0/REST/status"
 const history_uri = "/API/v1.0/REST/smshistory"
 
-var (
-	tr = &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client = &http.Client{Transport: tr}
+// historyPageSize is the pageSize requested on every /smshistory page.
+const historyPageSize = 100
 
+var (
 	listenAddress = flag.String("web.listen-address", ":9141",
 		"Address to listen on for telemetry")
 	metricsPath = flag.String("web.telemetry-path", "/metrics",
 		"Path under which to expose metrics")
 	configPath = flag.String("config.file-path", "",
-		"Path to environment file")
+		"Path to YAML configuration file listing the Mobyt accounts to scrape")
+	sessionTTL = flag.Duration("mobyt.session-ttl", 55*time.Minute,
+		"How long a Mobyt session key is cached before forcing a fresh login")
+	historyLookback = flag.Duration("history.lookback", time.Hour,
+		"How far back to look when fetching sms history")
+	historyTimezone = flag.String("history.timezone", "Europe/Paris",
+		"Timezone to use when computing the sms history lookback window")
+	mobytTimeout = flag.Duration("mobyt.timeout", 10*time.Second,
+		"Timeout for a single Mobyt API request")
+	mobytInsecureSkipVerify = flag.Bool("mobyt.insecure-skip-verify", false,
+		"Skip TLS certificate verification when talking to the Mobyt API")
+	webConfigFile = flag.String("web.config-file", "",
+		"Path to YAML file enabling TLS and/or basic auth on the telemetry endpoints")
+
+	// exporters holds one Exporter per configured target, keyed by its
+	// logical name, and is populated once at startup from the config file.
+	exporters map[string]*Exporter
 
 	// Metrics
 	up = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "up"),
 		"Was the last Mobyt query successful.",
-		nil, nil,
+		[]string{"name"}, nil,
 	)
 	smsSent = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "sms_sent"),
-		"Number of sms sent since one hour.",
-		[]string{"sender"}, nil,
+		"Number of sms sent in the lookback window, by sender and message type.",
+		[]string{"name", "sender", "message_type"}, nil,
+	)
+	smsRecipients = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sms_recipients"),
+		"Number of sms recipients in the lookback window, by sender and message type.",
+		[]string{"name", "sender", "message_type"}, nil,
 	)
 	smsMoney = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "sms_money"),
 		"Account current money",
-		[]string{"sender"}, nil,
+		[]string{"name", "sender"}, nil,
 	)
 	smsCredit = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "sms_credit"),
-		"Number of remaining sms.",
-		[]string{"type"}, nil,
+		"Number of remaining sms, by credit type.",
+		[]string{"name", "type"}, nil,
+	)
+	emailBandwidth = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "email_bandwidth"),
+		"Purchased email bandwidth.",
+		[]string{"name"}, nil,
+	)
+	emailExpiryTimestamp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "email_expiry_timestamp_seconds"),
+		"Expiry date of the email bandwidth, in seconds since the epoch.",
+		[]string{"name"}, nil,
 	)
 )
 
-func mobytRequest(endpoint string, auth []string) []byte {
+// Target describes one Mobyt account to scrape, as read from the
+// -config.file-path YAML file.
+type Target struct {
+	Name     string `yaml:"name"`
+	Endpoint string `yaml:"endpoint"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Config is the top-level shape of the -config.file-path YAML file.
+type Config struct {
+	Targets []Target `yaml:"targets"`
+}
 
-	//req := new(http.Request)
-	req, err := http.NewRequest(http.MethodGet, endpoint, http.NoBody)
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(cfg.Targets))
+	for _, target := range cfg.Targets {
+		if seen[target.Name] {
+			return nil, fmt.Errorf("duplicate target name %q in %s", target.Name, path)
+		}
+		seen[target.Name] = true
+	}
+
+	return &cfg, nil
+}
+
+// WebConfig mirrors the subset of the Prometheus exporter toolkit's
+// web-config format that this exporter supports: TLS termination and
+// basic-auth protection for the telemetry endpoints, as read from the
+// -web.config-file YAML file.
+type WebConfig struct {
+	TLSServerConfig *TLSServerConfig  `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig describes the certificate and client-auth policy to
+// serve the telemetry endpoints with.
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+func loadWebConfig(path string) (*WebConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg WebConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// tlsConfig builds a *tls.Config from c, loading the server certificate
+// and, if configured, the client CA pool used to verify client certs.
+func (c *TLSServerConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if c.ClientCAFile != "" {
+		caCert, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("could not parse client CA certificates from %s", c.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = caCertPool
+	}
+
+	if c.ClientAuthType != "" {
+		authType, ok := clientAuthTypes[c.ClientAuthType]
+		if !ok {
+			return nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuthType)
+		}
+		tlsConfig.ClientAuth = authType
+	}
+
+	return tlsConfig, nil
+}
+
+// credentialCache remembers recently-validated basic-auth credentials so
+// that bcrypt, which is intentionally slow, doesn't run on every scrape.
+type credentialCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+const credentialCacheTTL = time.Minute
+
+func newCredentialCache() *credentialCache {
+	return &credentialCache{entries: make(map[string]time.Time)}
+}
+
+func credentialCacheKey(username, password string) string {
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	return string(sum[:])
+}
+
+func (c *credentialCache) valid(username, password string) bool {
+	key := credentialCacheKey(username, password)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt, ok := c.entries[key]
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (c *credentialCache) remember(username, password string) {
+	key := credentialCacheKey(username, password)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = time.Now().Add(credentialCacheTTL)
+}
+
+// basicAuthMiddleware protects next with HTTP basic auth, checking
+// submitted credentials against bcrypt hashes in users and caching
+// recently-validated credentials to keep bcrypt off the hot path.
+func basicAuthMiddleware(next http.Handler, users map[string]string) http.Handler {
+	cache := newCredentialCache()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mobyt-exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		hash, ok := users[username]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mobyt-exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !cache.valid(username, password) {
+			if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="mobyt-exporter"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			cache.remember(username, password)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mobytRequest issues an authenticated GET against the Mobyt API, reusing
+// the exporter's cached session. If the session is rejected with a 401/403
+// it is invalidated and the request is retried once with a fresh login.
+func (e *Exporter) mobytRequest(ctx context.Context, endpoint string) ([]byte, error) {
+	return e.mobytRequestAllowRetry(ctx, endpoint, true)
+}
+
+func (e *Exporter) mobytRequestAllowRetry(ctx context.Context, endpoint string, allowRetry bool) ([]byte, error) {
+	auth, err := e.getAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, http.NoBody)
+	if err != nil {
+		return nil, err
 	}
 
 	// TODO add check len
@@ -148,75 +373,187 @@ func mobytRequest(endpoint string, auth []string) []byte {
 	req.Header.Set("session_key", auth[1])
 
 	log.Printf("Requesting %s", endpoint)
-	res, err := client.Do(req)
+	res, err := e.httpClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		if allowRetry {
+			log.Printf("Mobyt session rejected with status %d, forcing a fresh login", res.StatusCode)
+			e.invalidateSession()
+			return e.mobytRequestAllowRetry(ctx, endpoint, false)
+		}
+		return nil, fmt.Errorf("mobyt request failed with status %d after session refresh", res.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 	//fmt.Println(string(body))
-	return body
+	return body, nil
+}
+
+// fetchSMSHistory walks every page of /smshistory starting at the given
+// "from" timestamp, accumulating all entries across pages until
+// pageNumber*historyPageSize covers the reported Total.
+func (e *Exporter) fetchSMSHistory(ctx context.Context, from string) ([]SMSHistoryEntry, error) {
+	var entries []SMSHistoryEntry
+
+	for pageNumber := 1; ; pageNumber++ {
+		url := fmt.Sprintf("%s%s?from=%s&pageNumber=%d&pageSize=%d",
+			e.mobytEndpoint, history_uri, from, pageNumber, historyPageSize)
+		body, err := e.mobytRequest(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := parseSMSHistory(body)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, page.SmsHistory...)
+
+		if pageNumber*historyPageSize >= page.Total {
+			break
+		}
+	}
+
+	return entries, nil
 }
 
-func getSMSLastHourSent(body []byte) int {
-	var sms_sent SMSHistory
-	if err := json.Unmarshal(body, &sms_sent); err != nil {
-		log.Fatalln(err)
+func parseSMSHistory(body []byte) (*SMSHistory, error) {
+	var history SMSHistory
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, err
 	}
 
-	return sms_sent.Total
+	return &history, nil
 }
 
-func getSMSCredit(body []byte) (float64, int) {
-	var sms_credit SMSCredit
-	if err := json.Unmarshal(body, &sms_credit); err != nil {
-		log.Fatalln(err)
+func parseSMSCredit(body []byte) (*SMSCredit, error) {
+	var credit SMSCredit
+	if err := json.Unmarshal(body, &credit); err != nil {
+		return nil, err
 	}
 
-	return sms_credit.Money, sms_credit.Sms[0].Quantity
+	return &credit, nil
+}
+
+// sessionCache holds the Mobyt session obtained from login, so that
+// scrapes can reuse it instead of logging in on every Collect call.
+type sessionCache struct {
+	mu         sync.Mutex
+	userKey    string
+	sessionKey string
+	expiresAt  time.Time
 }
 
 type Exporter struct {
-	mobytEndpoint, mobytUsername, mobytPassword string
+	name          string
+	mobytEndpoint string
+	mobytUsername string
+	mobytPassword string
+	session       sessionCache
+	httpClient    *http.Client
 }
 
-func NewExporter(mobytEndpoint string, mobytUsername string, mobytPassword string) *Exporter {
+func NewExporter(name string, mobytEndpoint string, mobytUsername string, mobytPassword string) *Exporter {
 	return &Exporter{
+		name:          name,
 		mobytEndpoint: mobytEndpoint,
 		mobytUsername: mobytUsername,
 		mobytPassword: mobytPassword,
+		httpClient: &http.Client{
+			Timeout: *mobytTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: *mobytInsecureSkipVerify},
+			},
+		},
 	}
 }
 
+// getAuth returns the cached [userKey, sessionKey] pair, logging in again
+// if it is missing or has expired.
+func (e *Exporter) getAuth(ctx context.Context) ([]string, error) {
+	e.session.mu.Lock()
+	defer e.session.mu.Unlock()
+
+	if e.session.sessionKey != "" && time.Now().Before(e.session.expiresAt) {
+		return []string{e.session.userKey, e.session.sessionKey}, nil
+	}
+
+	auth, err := e.LoadMobytIdSessionMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.session.userKey = auth[0]
+	e.session.sessionKey = auth[1]
+	e.session.expiresAt = time.Now().Add(*sessionTTL)
+
+	return auth, nil
+}
+
+// invalidateSession clears the cached session so the next getAuth call
+// forces a fresh login.
+func (e *Exporter) invalidateSession() {
+	e.session.mu.Lock()
+	defer e.session.mu.Unlock()
+	e.session.sessionKey = ""
+}
+
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- up
 	ch <- smsSent
+	ch <- smsRecipients
 	ch <- smsMoney
 	ch <- smsCredit
+	ch <- emailBandwidth
+	ch <- emailExpiryTimestamp
 }
 
-func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	mobytIdSessionKey, err := e.LoadMobytIdSessionMap()
-	if err != nil {
+// collectWithContext runs a scrape of e, bounded by ctx, and reports the
+// result as the up metric alongside whatever metrics were gathered.
+func collectWithContext(ctx context.Context, e *Exporter, ch chan<- prometheus.Metric) {
+	if err := e.HitMobytRestApisAndUpdateMetrics(ctx, ch); err != nil {
 		ch <- prometheus.MustNewConstMetric(
-			up, prometheus.GaugeValue, 0,
+			up, prometheus.GaugeValue, 0, e.name,
 		)
 		log.Println(err)
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(
-		up, prometheus.GaugeValue, 1,
+		up, prometheus.GaugeValue, 1, e.name,
 	)
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	collectWithContext(context.Background(), e, ch)
+}
+
+// probeCollector adapts a single /probe request into a prometheus.Collector,
+// so that the request's context (and its Prometheus scrape-timeout) bounds
+// every Mobyt call made while gathering that request's metrics.
+type probeCollector struct {
+	ctx      context.Context
+	exporter *Exporter
+}
+
+func (p *probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	p.exporter.Describe(ch)
+}
 
-	e.HitMobytRestApisAndUpdateMetrics(mobytIdSessionKey, ch)
+func (p *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	collectWithContext(p.ctx, p.exporter, ch)
 }
 
-func (e *Exporter) LoadMobytIdSessionMap() ([]string, error) {
+func (e *Exporter) LoadMobytIdSessionMap(ctx context.Context) ([]string, error) {
 
-	req, err := http.NewRequest("GET", e.mobytEndpoint+login_uri, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.mobytEndpoint+login_uri, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -225,7 +562,7 @@ func (e *Exporter) LoadMobytIdSessionMap() ([]string, error) {
 	req.SetBasicAuth(e.mobytUsername, e.mobytPassword)
 	// Make request and show output.
 	log.Printf("Getting session authentification")
-	resp, err := client.Do(req)
+	resp, err := e.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -247,73 +584,167 @@ func (e *Exporter) LoadMobytIdSessionMap() ([]string, error) {
 	return informationKey, nil
 }
 
-func (e *Exporter) HitMobytRestApisAndUpdateMetrics(auth []string, ch chan<- prometheus.Metric) {
+// historyKey groups sms history entries by the dimensions we report on.
+type historyKey struct {
+	sender, messageType string
+}
+
+func (e *Exporter) HitMobytRestApisAndUpdateMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
 	// Get SMS Credits
 	log.Printf("Get sms credit")
 	url := e.mobytEndpoint + status_uri + "?getMoney=true&typeAliases=true"
-	sms_money, sms_credit := getSMSCredit(mobytRequest(url, auth))
-	//fmt.Println("sms_credit")
+	body, err := e.mobytRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	credit, err := parseSMSCredit(body)
+	if err != nil {
+		return err
+	}
 	ch <- prometheus.MustNewConstMetric(
-		smsCredit, prometheus.GaugeValue, float64(sms_credit), "",
+		smsMoney, prometheus.GaugeValue, credit.Money, e.name, "",
 	)
-	//fmt.Println("sms_money")
+	for _, sms := range credit.Sms {
+		ch <- prometheus.MustNewConstMetric(
+			smsCredit, prometheus.GaugeValue, float64(sms.Quantity), e.name, sms.Type,
+		)
+	}
 	ch <- prometheus.MustNewConstMetric(
-		smsMoney, prometheus.GaugeValue, sms_money, "",
+		emailBandwidth, prometheus.GaugeValue, credit.Email.BandWidth, e.name,
 	)
+	if expiry, err := time.Parse("2006-01-02", credit.Email.Expiry); err != nil {
+		log.Printf("Could not parse email expiry date %q: %v", credit.Email.Expiry, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(
+			emailExpiryTimestamp, prometheus.GaugeValue, float64(expiry.Unix()), e.name,
+		)
+	}
 
-	// Get
-	log.Printf("Get one hour sms history")
-	current_time := time.Now()
+	// Get sms history
+	log.Printf("Get sms history for the last %s", *historyLookback)
 	// define the mobyt time zone
-	location, err := time.LoadLocation("Europe/Paris")
+	location, err := time.LoadLocation(*historyTimezone)
 	if err != nil {
-		log.Fatalln(err)
-	}
-	localtime := current_time.In(location)
-	one_hour_ago := localtime.Add(-1 * time.Hour)
-	date := fmt.Sprintf("%d%02d%02d%02d%02d%02d", one_hour_ago.Year(), one_hour_ago.Month(), one_hour_ago.Day(),
-		one_hour_ago.Hour(), one_hour_ago.Minute(), one_hour_ago.Second())
-	url = e.mobytEndpoint + history_uri + "?from=" + date
-	sms_sent := getSMSLastHourSent(mobytRequest(url, auth))
-	ch <- prometheus.MustNewConstMetric(
-		smsSent, prometheus.GaugeValue, float64(sms_sent), "",
-	)
+		return err
+	}
+	lookbackStart := time.Now().In(location).Add(-*historyLookback)
+	date := fmt.Sprintf("%d%02d%02d%02d%02d%02d", lookbackStart.Year(), lookbackStart.Month(), lookbackStart.Day(),
+		lookbackStart.Hour(), lookbackStart.Minute(), lookbackStart.Second())
+	historyEntries, err := e.fetchSMSHistory(ctx, date)
+	if err != nil {
+		return err
+	}
+
+	sent := map[historyKey]int{}
+	recipients := map[historyKey]int{}
+	for _, entry := range historyEntries {
+		key := historyKey{sender: entry.Sender, messageType: entry.MessageType}
+		sent[key]++
+		recipients[key] += entry.NumRecipients
+	}
+	for key, count := range sent {
+		ch <- prometheus.MustNewConstMetric(
+			smsSent, prometheus.GaugeValue, float64(count), e.name, key.sender, key.messageType,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			smsRecipients, prometheus.GaugeValue, float64(recipients[key]), e.name, key.sender, key.messageType,
+		)
+	}
 
 	log.Println("Endpoint scraped")
+	return nil
+}
+
+// probeHandler scrapes a single target, named by the "target" query
+// parameter, into its own ad-hoc registry so that each Mobyt account is
+// scraped and timed out independently by Prometheus.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	targetName := r.URL.Query().Get("target")
+	if targetName == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	exporter, ok := exporters[targetName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target %q", targetName), http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	if scrapeTimeoutSeconds := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); scrapeTimeoutSeconds != "" {
+		if seconds, err := strconv.ParseFloat(scrapeTimeoutSeconds, 64); err == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+			defer cancel()
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&probeCollector{ctx: ctx, exporter: exporter})
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }
 
 func main() {
 	flag.Parse()
 
 	configFile := *configPath
-	if configFile != "" {
-		log.Printf("Loading %s env file.\n", configFile)
-		if err := godotenv.Load(configFile); err != nil {
-			log.Printf("Error loading %s env file.\n", configFile)
-		}
-	} else {
-		if err := godotenv.Load(); err != nil {
-			log.Printf("Error loading .env file, assume env variable are set.")
-		}
+	if configFile == "" {
+		log.Fatalln("-config.file-path is required")
+	}
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatalf("Error loading %s config file: %v", configFile, err)
+	}
+
+	exporters = make(map[string]*Exporter, len(cfg.Targets))
+	for _, target := range cfg.Targets {
+		exporters[target.Name] = NewExporter(target.Name, target.Endpoint, target.Username, target.Password)
+		log.Printf("Configured target %q using endpoint: %s", target.Name, target.Endpoint)
 	}
 
-	mobytEndpoint := os.Getenv("MOBYT_ENDPOINT")
-	mobytUsername := os.Getenv("MOBYT_USERNAME")
-	mobytPassword := os.Getenv("MOBYT_PASSWORD")
+	var webConfig *WebConfig
+	if *webConfigFile != "" {
+		webConfig, err = loadWebConfig(*webConfigFile)
+		if err != nil {
+			log.Fatalf("Error loading %s web config file: %v", *webConfigFile, err)
+		}
+	}
 
-	exporter := NewExporter(mobytEndpoint, mobytUsername, mobytPassword)
-	prometheus.MustRegister(exporter)
-	log.Printf("Using connection endpoint: %s", mobytEndpoint)
+	var metricsHandler http.Handler = promhttp.Handler()
+	var probeHandlerFunc http.Handler = http.HandlerFunc(probeHandler)
+	if webConfig != nil && len(webConfig.BasicAuthUsers) > 0 {
+		metricsHandler = basicAuthMiddleware(metricsHandler, webConfig.BasicAuthUsers)
+		probeHandlerFunc = basicAuthMiddleware(probeHandlerFunc, webConfig.BasicAuthUsers)
+	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(*metricsPath, metricsHandler)
+	http.Handle("/probe", probeHandlerFunc)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>Mobyt Exporter</title></head>
 		<body>
 		<h1>Mobyt Exporter,/h1>
 		<p><a href='` + *metricsPath + `'>Metrics</a></p>
+		<p>Use <code>/probe?target=&lt;name&gt;</code> to scrape a configured account.</p>
 		</body>
 		</html>`))
 	})
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+
+	if webConfig == nil || webConfig.TLSServerConfig == nil {
+		log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	}
+
+	tlsConfig, err := webConfig.TLSServerConfig.tlsConfig()
+	if err != nil {
+		log.Fatalf("Error configuring TLS: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:      *listenAddress,
+		TLSConfig: tlsConfig,
+	}
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }